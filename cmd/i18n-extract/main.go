@@ -0,0 +1,70 @@
+// Command i18n-extract scans a Go source tree for i18n.Text, i18n.TextN,
+// and i18n.TextC call sites and writes (or merges into an existing) a
+// .i18n catalog template, so translators always have an up-to-date list of
+// keys without anyone hand-editing catalogs.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/richardwilkes/toolbox/i18n"
+)
+
+func main() {
+	src := flag.String("src", ".", "root of the Go source tree to scan")
+	out := flag.String("out", "", "catalog file to write (defaults to stdout)")
+	existing := flag.String("existing", "", "existing .i18n catalog to merge translations from")
+	flag.Parse()
+
+	keys, err := i18n.Extract(*src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Read any existing catalog fully into memory before opening -out,
+	// since -existing and -out are commonly the same path (updating a
+	// catalog in place): creating -out would truncate that path, and the
+	// truncation isn't undone by having opened it for reading first, so
+	// the read must happen first and completely.
+	var existingData []byte
+	if *existing != "" {
+		if existingData, err = ioutil.ReadFile(*existing); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, ferr := os.Create(*out)
+		if ferr != nil {
+			fmt.Fprintln(os.Stderr, ferr)
+			os.Exit(1)
+		}
+		defer func() { _ = f.Close() }()
+		w = f
+	}
+
+	if *existing == "" {
+		if err = i18n.WriteTemplate(keys, w); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(i18n.WriteTemplate(keys, pw))
+	}()
+	if err = i18n.Merge(bytes.NewReader(existingData), pr, w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
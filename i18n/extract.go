@@ -0,0 +1,139 @@
+package i18n
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ExtractedKey is one i18n key discovered by Extract, together with the
+// "file:line" source locations it was found at.
+type ExtractedKey struct {
+	Key  string
+	Refs []string
+}
+
+// Extract walks the Go source tree rooted at dir, looking for calls of the
+// form i18n.Text(...), i18n.TextN(...), and i18n.TextC(...) whose relevant
+// arguments are string literals, and returns every key found, deduplicated
+// and sorted, each annotated with the source references it came from.
+// TextC's context and text are combined using the same ctxSeparator used
+// at lookup time, and TextN contributes both its singular and plural
+// strings as separate keys.
+func Extract(dir string) ([]ExtractedKey, error) {
+	refs := make(map[string]map[string]bool)
+	fset := token.NewFileSet()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		file, perr := parser.ParseFile(fset, path, nil, 0)
+		if perr != nil {
+			return perr
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			ident, ok := sel.X.(*ast.Ident)
+			if !ok || ident.Name != "i18n" {
+				return true
+			}
+			ref := fmt.Sprintf("%s:%d", path, fset.Position(call.Pos()).Line)
+			switch sel.Sel.Name {
+			case "Text":
+				if key, ok := stringLitArg(call.Args, 0); ok {
+					addRef(refs, key, ref)
+				}
+			case "TextN":
+				if key, ok := stringLitArg(call.Args, 0); ok {
+					addRef(refs, key, ref)
+				}
+				if key, ok := stringLitArg(call.Args, 1); ok {
+					addRef(refs, key, ref)
+				}
+			case "TextC":
+				ctx, ctxOK := stringLitArg(call.Args, 0)
+				text, textOK := stringLitArg(call.Args, 1)
+				if ctxOK && textOK {
+					addRef(refs, ctx+ctxSeparator+text, ref)
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]ExtractedKey, 0, len(refs))
+	for key, set := range refs {
+		list := make([]string, 0, len(set))
+		for ref := range set {
+			list = append(list, ref)
+		}
+		sort.Strings(list)
+		keys = append(keys, ExtractedKey{Key: key, Refs: list})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys, nil
+}
+
+func addRef(refs map[string]map[string]bool, key, ref string) {
+	set, ok := refs[key]
+	if !ok {
+		set = make(map[string]bool)
+		refs[key] = set
+	}
+	set[ref] = true
+}
+
+func stringLitArg(args []ast.Expr, idx int) (string, bool) {
+	if idx >= len(args) {
+		return "", false
+	}
+	lit, ok := args[idx].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// WriteTemplate writes keys out as a .i18n template: each key is preceded
+// by a comment for each of its source references and given an empty value
+// ready for a translator to fill in.
+func WriteTemplate(keys []ExtractedKey, w io.Writer) error {
+	for _, key := range keys {
+		for _, ref := range key.Refs {
+			if _, err := fmt.Fprintf(w, "# %s\n", ref); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "k:%s\n", strconv.Quote(key.Key)); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "v:%s\n\n", strconv.Quote("")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package i18n
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// POExtension is the file name extension for gettext source catalogs.
+	POExtension = ".po"
+	// MOExtension is the file name extension for compiled gettext catalogs.
+	MOExtension = ".mo"
+)
+
+// ctxSeparator mirrors the EOT (0x04) byte gettext uses to join a msgctxt
+// and msgid into a single catalog key.
+const ctxSeparator = "\x04"
+
+// moLittleEndianMagic and moBigEndianMagic are the magic numbers found at
+// the start of a compiled .mo file, depending on the byte order it was
+// written with.
+const (
+	moLittleEndianMagic = 0x950412de
+	moBigEndianMagic    = 0xde120495
+)
+
+// TextN returns a localized version of singular or plural, chosen according
+// to n and the current Language's plural rule, or singular/plural
+// (following the English plural rule) if no catalog entry exists.
+func TextN(singular, plural string, n int) string {
+	once.Do(loadCatalogs)
+	if result, ok := pluralLookup(singular, Language, n); ok {
+		return result
+	}
+	for _, language := range Languages {
+		if result, ok := pluralLookup(singular, language, n); ok {
+			return result
+		}
+	}
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// TextC returns a localized version of text disambiguated by context, or
+// the original text if no catalog entry exists.
+func TextC(context, text string) string {
+	once.Do(loadCatalogs)
+	key := context + ctxSeparator + text
+	if result := lookup(key, Language); result != "" {
+		return result
+	}
+	for _, language := range Languages {
+		if result := lookup(key, language); result != "" {
+			return result
+		}
+	}
+	return text
+}
+
+func pluralLookup(singular, language string, n int) (string, bool) {
+	for _, lang := range hierarchy(language) {
+		forms, ok := pluralLangMap[lang][singular]
+		if !ok {
+			continue
+		}
+		idx := 0
+		if fn, ok := pluralExprMap[lang]; ok {
+			idx = fn(n)
+		} else if n != 1 {
+			idx = 1
+		}
+		if idx >= 0 && idx < len(forms) && forms[idx] != "" {
+			return forms[idx], true
+		}
+	}
+	return "", false
+}
+
+// loadGettextDir loads every .po and .mo file found in
+// Dir/lang/LC_MESSAGES/, merging the result into langMap[lang] (and
+// pluralLangMap[lang] for plural entries), keyed by the lowercased lang.
+func loadGettextDir(lang string) {
+	msgDir := filepath.Join(Dir, lang, "LC_MESSAGES")
+	fi, err := ioutil.ReadDir(msgDir)
+	if err != nil {
+		return
+	}
+	lowerLang := strings.ToLower(lang)
+	for _, one := range fi {
+		if one.IsDir() {
+			continue
+		}
+		name := one.Name()
+		path := filepath.Join(msgDir, name)
+		switch filepath.Ext(name) {
+		case POExtension:
+			loadPO(path, lowerLang)
+		case MOExtension:
+			loadMO(path, lowerLang)
+		}
+	}
+}
+
+func ensureLangMap(lang string) map[string]string {
+	m, ok := langMap[lang]
+	if !ok {
+		m = make(map[string]string)
+		langMap[lang] = m
+	}
+	return m
+}
+
+func ensurePluralMap(lang string) map[string][]string {
+	m, ok := pluralLangMap[lang]
+	if !ok {
+		m = make(map[string][]string)
+		pluralLangMap[lang] = m
+	}
+	return m
+}
+
+// loadPO parses a gettext source (.po) catalog, merging its msgid/msgstr
+// pairs into langMap[lang], its plural forms into pluralLangMap[lang], and,
+// if present, compiling the header's Plural-Forms expression into
+// pluralExprMap[lang].
+func loadPO(path, lang string) {
+	file, err := os.Open(path)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	defer func() {
+		if cerr := file.Close(); cerr != nil {
+			Log.Error(cerr)
+		}
+	}()
+	catalog := ensureLangMap(lang)
+	plurals := ensurePluralMap(lang)
+
+	var ctx, id, idPlural, pending string
+	msgstrs := make(map[int]string)
+	flush := func() {
+		defer func() {
+			ctx, id, idPlural, pending = "", "", "", ""
+			msgstrs = make(map[int]string)
+		}()
+		if id == "" && ctx == "" && len(msgstrs) == 0 {
+			return
+		}
+		if id == "" {
+			// The header entry: msgid "" msgstr "Plural-Forms: ...\n...".
+			parseHeader(msgstrs[0], lang)
+			return
+		}
+		key := id
+		if ctx != "" {
+			key = ctx + ctxSeparator + id
+		}
+		if idPlural != "" {
+			max := -1
+			for idx := range msgstrs {
+				if idx > max {
+					max = idx
+				}
+			}
+			forms := make([]string, max+1)
+			for idx, s := range msgstrs {
+				forms[idx] = s
+			}
+			plurals[key] = forms
+		} else if s, ok := msgstrs[0]; ok {
+			catalog[key] = s
+		}
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "msgctxt "):
+			ctx = unquotePO(line[len("msgctxt "):])
+			pending = "ctx"
+		case strings.HasPrefix(line, "msgid_plural "):
+			idPlural = unquotePO(line[len("msgid_plural "):])
+			pending = "idPlural"
+		case strings.HasPrefix(line, "msgid "):
+			id = unquotePO(line[len("msgid "):])
+			pending = "id"
+		case strings.HasPrefix(line, "msgstr["):
+			end := strings.IndexByte(line, ']')
+			if end == -1 {
+				Log.Errorf("malformed msgstr[] in %s\n", path)
+				continue
+			}
+			idx, cerr := strconv.Atoi(line[len("msgstr["):end])
+			if cerr != nil {
+				Log.Errorf("malformed msgstr[] in %s\n", path)
+				continue
+			}
+			msgstrs[idx] = unquotePO(line[end+1:])
+			pending = "str:" + strconv.Itoa(idx)
+		case strings.HasPrefix(line, "msgstr "):
+			msgstrs[0] = unquotePO(line[len("msgstr "):])
+			pending = "str:0"
+		case strings.HasPrefix(line, "\""):
+			appendPOContinuation(pending, line, &ctx, &id, &idPlural, msgstrs)
+		}
+	}
+	flush()
+	if err = scanner.Err(); err != nil {
+		Log.Error(err)
+	}
+}
+
+func appendPOContinuation(pending, line string, ctx, id, idPlural *string, msgstrs map[int]string) {
+	s := unquotePO(line)
+	switch {
+	case pending == "ctx":
+		*ctx += s
+	case pending == "id":
+		*id += s
+	case pending == "idPlural":
+		*idPlural += s
+	case strings.HasPrefix(pending, "str:"):
+		idx, err := strconv.Atoi(pending[len("str:"):])
+		if err == nil {
+			msgstrs[idx] += s
+		}
+	}
+}
+
+func unquotePO(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "\"") {
+		if v, err := strconv.Unquote(s); err == nil {
+			return v
+		}
+	}
+	return s
+}
+
+// parseHeader extracts the Plural-Forms header, if any, from a catalog's
+// empty-msgid entry and compiles its expression into pluralExprMap[lang].
+func parseHeader(header, lang string) {
+	for _, line := range strings.Split(header, "\n") {
+		const prefix = "Plural-Forms:"
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		value := strings.TrimSpace(line[len(prefix):])
+		fn, err := parsePluralForms(value)
+		if err != nil {
+			Log.Errorf("invalid Plural-Forms header for %s: %v\n", lang, err)
+			return
+		}
+		pluralExprMap[lang] = fn
+		return
+	}
+}
+
+func parsePluralForms(value string) (pluralEval, error) {
+	var exprStr string
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "plural=") {
+			exprStr = strings.TrimSpace(strings.TrimPrefix(part, "plural="))
+		}
+	}
+	if exprStr == "" {
+		return nil, fmt.Errorf("no plural expression present")
+	}
+	return parsePluralExpr(exprStr)
+}
+
+// loadMO parses a compiled gettext (.mo) catalog, merging its contents into
+// langMap[lang] and pluralLangMap[lang] the same way loadPO does. Both the
+// little- and big-endian magic numbers are honored.
+func loadMO(path, lang string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	if len(data) < 28 {
+		Log.Errorf("%s is too small to be a valid .mo file\n", path)
+		return
+	}
+	var order binary.ByteOrder
+	switch binary.LittleEndian.Uint32(data[0:4]) {
+	case moLittleEndianMagic:
+		order = binary.LittleEndian
+	case moBigEndianMagic:
+		order = binary.BigEndian
+	default:
+		Log.Errorf("%s has an unrecognized .mo magic number\n", path)
+		return
+	}
+	nstrings := order.Uint32(data[8:12])
+	origTableOffset := order.Uint32(data[12:16])
+	transTableOffset := order.Uint32(data[16:20])
+
+	readString := func(tableOffset, i uint32) (string, bool) {
+		entry := tableOffset + i*8
+		if int64(entry)+8 > int64(len(data)) {
+			Log.Errorf("%s has a truncated string table\n", path)
+			return "", false
+		}
+		length := order.Uint32(data[entry : entry+4])
+		offset := order.Uint32(data[entry+4 : entry+8])
+		if int64(offset)+int64(length) > int64(len(data)) {
+			Log.Errorf("%s has truncated string data\n", path)
+			return "", false
+		}
+		return string(data[offset : offset+length]), true
+	}
+
+	catalog := ensureLangMap(lang)
+	plurals := ensurePluralMap(lang)
+	for i := uint32(0); i < nstrings; i++ {
+		origStr, ok := readString(origTableOffset, i)
+		if !ok {
+			continue
+		}
+		transStr, ok := readString(transTableOffset, i)
+		if !ok {
+			continue
+		}
+		if origStr == "" {
+			parseHeader(transStr, lang)
+			continue
+		}
+		id := origStr
+		ctx := ""
+		if idx := strings.IndexByte(id, '\x04'); idx != -1 {
+			ctx = id[:idx]
+			id = id[idx+1:]
+		}
+		if sep := strings.IndexByte(id, 0); sep != -1 {
+			key := id[:sep]
+			if ctx != "" {
+				key = ctx + ctxSeparator + key
+			}
+			plurals[key] = strings.Split(transStr, "\x00")
+		} else {
+			key := id
+			if ctx != "" {
+				key = ctx + ctxSeparator + id
+			}
+			catalog[key] = transStr
+		}
+	}
+}
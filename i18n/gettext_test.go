@@ -0,0 +1,174 @@
+package i18n
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func resetCatalogsForTest() {
+	langMap = make(map[string]map[string]string)
+	pluralLangMap = make(map[string]map[string][]string)
+	pluralExprMap = make(map[string]pluralEval)
+	hierMap = make(map[string][]string)
+}
+
+func TestParsePluralFormsGermanic(t *testing.T) {
+	fn, err := parsePluralForms("nplurals=2; plural=(n != 1);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn(1) != 0 {
+		t.Fatalf("expected index 0 for n=1, got %d", fn(1))
+	}
+	if fn(0) != 1 || fn(2) != 1 {
+		t.Fatalf("expected index 1 for n=0 and n=2")
+	}
+}
+
+func TestParsePluralFormsSlavic(t *testing.T) {
+	fn, err := parsePluralForms(
+		"nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[int]int{1: 0, 21: 0, 2: 1, 3: 1, 4: 1, 22: 1, 5: 2, 11: 2, 12: 2, 100: 2}
+	for n, want := range cases {
+		if got := fn(n); got != want {
+			t.Errorf("n=%d: expected index %d, got %d", n, want, got)
+		}
+	}
+}
+
+func TestLoadPOWithPluralsAndContext(t *testing.T) {
+	resetCatalogsForTest()
+	src := "msgid \"\"\n" +
+		"msgstr \"\"\n" +
+		"\"Plural-Forms: nplurals=2; plural=(n != 1);\\n\"\n" +
+		"\n" +
+		"msgctxt \"menu\"\n" +
+		"msgid \"Open\"\n" +
+		"msgstr \"Ouvrir\"\n" +
+		"\n" +
+		"msgid \"file\"\n" +
+		"msgid_plural \"files\"\n" +
+		"msgstr[0] \"fichier\"\n" +
+		"msgstr[1] \"fichiers\"\n"
+
+	dir, err := ioutil.TempDir("", "i18n-po-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.po")
+	if err = ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loadPO(path, "fr")
+
+	if got := lookup("menu"+ctxSeparator+"Open", "fr"); got != "Ouvrir" {
+		t.Fatalf("expected %q, got %q", "Ouvrir", got)
+	}
+	if got, ok := pluralLookup("file", "fr", 1); !ok || got != "fichier" {
+		t.Fatalf("expected singular %q, got %q (ok=%v)", "fichier", got, ok)
+	}
+	if got, ok := pluralLookup("file", "fr", 2); !ok || got != "fichiers" {
+		t.Fatalf("expected plural %q, got %q (ok=%v)", "fichiers", got, ok)
+	}
+}
+
+// buildMO assembles a minimal little-endian .mo file containing entries,
+// matching the layout loadMO expects, so loadMO can be exercised without a
+// real gettext toolchain.
+func buildMO(t *testing.T, entries map[string]string) []byte {
+	t.Helper()
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	const headerSize = 28
+	origTableOffset := uint32(headerSize)
+	transTableOffset := origTableOffset + uint32(len(keys)*8)
+	dataStart := transTableOffset + uint32(len(keys)*8)
+
+	var origData, transData bytes.Buffer
+	origLengths := make([]uint32, len(keys))
+	origOffsets := make([]uint32, len(keys))
+	transLengths := make([]uint32, len(keys))
+	transOffsets := make([]uint32, len(keys))
+
+	for i, k := range keys {
+		origLengths[i] = uint32(len(k))
+		origOffsets[i] = dataStart + uint32(origData.Len())
+		origData.WriteString(k)
+	}
+	transBase := dataStart + uint32(origData.Len())
+	for i, k := range keys {
+		v := entries[k]
+		transLengths[i] = uint32(len(v))
+		transOffsets[i] = transBase + uint32(transData.Len())
+		transData.WriteString(v)
+	}
+
+	buf := new(bytes.Buffer)
+	write32 := func(v uint32) {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write32(moLittleEndianMagic)
+	write32(0) // revision
+	write32(uint32(len(keys)))
+	write32(origTableOffset)
+	write32(transTableOffset)
+	write32(0) // hash table size
+	write32(0) // hash table offset
+	for i := range keys {
+		write32(origLengths[i])
+		write32(origOffsets[i])
+	}
+	for i := range keys {
+		write32(transLengths[i])
+		write32(transOffsets[i])
+	}
+	buf.Write(origData.Bytes())
+	buf.Write(transData.Bytes())
+	return buf.Bytes()
+}
+
+func TestLoadMOWithPluralsAndHeader(t *testing.T) {
+	resetCatalogsForTest()
+	data := buildMO(t, map[string]string{
+		"":              "Plural-Forms: nplurals=2; plural=(n != 1);\n",
+		"Save":          "Enregistrer",
+		"file\x00files": "fichier\x00fichiers",
+	})
+	dir, err := ioutil.TempDir("", "i18n-mo-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.mo")
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loadMO(path, "de")
+
+	if got := lookup("Save", "de"); got != "Enregistrer" {
+		t.Fatalf("expected %q, got %q", "Enregistrer", got)
+	}
+	if got, ok := pluralLookup("file", "de", 1); !ok || got != "fichier" {
+		t.Fatalf("singular lookup failed: %q (ok=%v)", got, ok)
+	}
+	if got, ok := pluralLookup("file", "de", 5); !ok || got != "fichiers" {
+		t.Fatalf("plural lookup failed: %q (ok=%v)", got, ok)
+	}
+}
@@ -36,45 +36,53 @@ var (
 	// to the value of the LANGUAGE environment variable.
 	Languages = strings.Split(os.Getenv("LANGUAGE"), ":")
 	// Log is set to discard by default.
-	Log      logadapter.ErrorLogger = &logadapter.Discarder{}
-	once     sync.Once
-	langMap  = make(map[string]map[string]string)
-	hierLock sync.Mutex
-	hierMap  = make(map[string][]string)
+	Log           logadapter.ErrorLogger = &logadapter.Discarder{}
+	once          sync.Once
+	langMap       = make(map[string]map[string]string)
+	pluralLangMap = make(map[string]map[string][]string)
+	pluralExprMap = make(map[string]pluralEval)
+	hierLock      sync.Mutex
+	hierMap       = make(map[string][]string)
 )
 
-// Text returns a localized version of the text if one exists, or the original
-// text if not.
-func Text(text string) string {
-	once.Do(func() {
-		if Dir == "" {
-			path, err := os.Executable()
-			if err != nil {
-				return
-			}
-			path, err = filepath.EvalSymlinks(path)
-			if err != nil {
-				return
-			}
-			path, err = filepath.Abs(fs.TrimExtension(path) + "_i18n")
-			if err != nil {
-				return
-			}
-			Dir = path
+// loadCatalogs scans Dir once, loading both the native .i18n catalogs and
+// any gettext .po/.mo catalogs found in Dir/<lang>/LC_MESSAGES/.
+func loadCatalogs() {
+	if Dir == "" {
+		path, err := os.Executable()
+		if err != nil {
+			return
 		}
-		fi, err := ioutil.ReadDir(Dir)
+		path, err = filepath.EvalSymlinks(path)
 		if err != nil {
 			return
 		}
-		for _, one := range fi {
-			if !one.IsDir() {
-				name := one.Name()
-				if filepath.Ext(name) == Extension {
-					load(name)
-				}
-			}
+		path, err = filepath.Abs(fs.TrimExtension(path) + "_i18n")
+		if err != nil {
+			return
+		}
+		Dir = path
+	}
+	fi, err := ioutil.ReadDir(Dir)
+	if err != nil {
+		return
+	}
+	for _, one := range fi {
+		name := one.Name()
+		if one.IsDir() {
+			loadGettextDir(name)
+			continue
+		}
+		if filepath.Ext(name) == Extension {
+			load(name)
 		}
-	})
+	}
+}
+
+// Text returns a localized version of the text if one exists, or the original
+// text if not.
+func Text(text string) string {
+	once.Do(loadCatalogs)
 
 	var result string
 	if result = lookup(text, Language); result != "" {
@@ -123,8 +131,7 @@ func hierarchy(language string) []string {
 func load(name string) {
 	path := filepath.Join(Dir, name)
 	if file, err := os.Open(path); err == nil {
-		translations := make(map[string]string)
-		langMap[strings.ToLower(name[:len(name)-len(Extension)])] = translations
+		translations := ensureLangMap(strings.ToLower(name[:len(name)-len(Extension)]))
 		var key string
 		var lineNum int
 		var lastKeyLineNum int
@@ -0,0 +1,121 @@
+package i18n
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// catalogEntry is one key's state while reading or merging a .i18n file.
+type catalogEntry struct {
+	comments []string
+	value    string
+	obsolete bool
+}
+
+// Merge reads an existing .i18n catalog and a freshly generated template
+// (as produced by WriteTemplate), and writes a merged catalog to w: keys
+// present in both keep their existing translation and pick up the
+// template's current source-reference comments; keys only in template are
+// added with an empty value; keys only in existing are kept, marked
+// "#~ obsolete" the way gettext does, rather than silently dropped.
+func Merge(existing, template io.Reader, w io.Writer) error {
+	existingEntries, existingOrder, err := readCatalog(existing)
+	if err != nil {
+		return err
+	}
+	templateEntries, templateOrder, err := readCatalog(template)
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]*catalogEntry, len(templateEntries)+len(existingEntries))
+	var order []string
+	for _, key := range templateOrder {
+		entry := templateEntries[key]
+		if old, ok := existingEntries[key]; ok {
+			entry.value = old.value
+		}
+		merged[key] = entry
+		order = append(order, key)
+	}
+	for _, key := range existingOrder {
+		if _, ok := merged[key]; ok {
+			continue
+		}
+		entry := existingEntries[key]
+		entry.obsolete = true
+		merged[key] = entry
+		order = append(order, key)
+	}
+
+	for _, key := range order {
+		entry := merged[key]
+		for _, comment := range entry.comments {
+			if _, err = fmt.Fprintf(w, "# %s\n", comment); err != nil {
+				return err
+			}
+		}
+		if entry.obsolete {
+			if _, err = fmt.Fprintln(w, "#~ obsolete"); err != nil {
+				return err
+			}
+		}
+		if _, err = fmt.Fprintf(w, "k:%s\n", strconv.Quote(key)); err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "v:%s\n\n", strconv.Quote(entry.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readCatalog reads a .i18n file (with the comment extensions Extract and
+// Merge use layered on top: "# ..." reference comments and a "#~ obsolete"
+// marker), returning each key's entry plus the order keys were seen in.
+func readCatalog(r io.Reader) (map[string]*catalogEntry, []string, error) {
+	entries := make(map[string]*catalogEntry)
+	var order []string
+	var pendingComments []string
+	var pendingObsolete bool
+	var key string
+	haveKey := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		trimmed := strings.TrimSpace(scanner.Text())
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#~ obsolete"):
+			pendingObsolete = true
+		case strings.HasPrefix(trimmed, "#"):
+			pendingComments = append(pendingComments, strings.TrimSpace(strings.TrimPrefix(trimmed, "#")))
+		case strings.HasPrefix(trimmed, "k:"):
+			value, err := strconv.Unquote(strings.TrimSpace(trimmed[2:]))
+			if err != nil {
+				return nil, nil, err
+			}
+			key = value
+			haveKey = true
+			entries[key] = &catalogEntry{comments: pendingComments, obsolete: pendingObsolete}
+			pendingComments, pendingObsolete = nil, false
+			order = append(order, key)
+		case strings.HasPrefix(trimmed, "v:"):
+			if !haveKey {
+				continue
+			}
+			value, err := strconv.Unquote(strings.TrimSpace(trimmed[2:]))
+			if err != nil {
+				return nil, nil, err
+			}
+			entries[key].value = value
+			haveKey = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, order, nil
+}
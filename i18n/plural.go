@@ -0,0 +1,332 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// pluralEval evaluates a gettext Plural-Forms expression for a given n,
+// returning the index of the plural form that should be used.
+type pluralEval func(n int) int
+
+// parsePluralExpr compiles the C-style expression found in a catalog's
+// "plural=" header fragment (e.g. "n != 1" or the Slavic three-form rule)
+// into a pluralEval. Booleans follow C conventions: true is 1, false is 0.
+func parsePluralExpr(s string) (pluralEval, error) {
+	tokens, err := tokenizePluralExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &pluralExprParser{tokens: tokens}
+	fn, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q", p.tokens[p.pos])
+	}
+	return fn, nil
+}
+
+func tokenizePluralExpr(s string) ([]string, error) {
+	var tokens []string
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j++
+			}
+			tokens = append(tokens, string(runes[i:j]))
+			i = j
+		case r == 'n':
+			tokens = append(tokens, "n")
+			i++
+		case strings.ContainsRune("()?:+-*/%", r):
+			tokens = append(tokens, string(r))
+			i++
+		case r == '!':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, "!=")
+				i += 2
+			} else {
+				tokens = append(tokens, "!")
+				i++
+			}
+		case r == '=' && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, "==")
+			i += 2
+		case r == '<':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, "<=")
+				i += 2
+			} else {
+				tokens = append(tokens, "<")
+				i++
+			}
+		case r == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, ">=")
+				i += 2
+			} else {
+				tokens = append(tokens, ">")
+				i++
+			}
+		case r == '&' && i+1 < len(runes) && runes[i+1] == '&':
+			tokens = append(tokens, "&&")
+			i += 2
+		case r == '|' && i+1 < len(runes) && runes[i+1] == '|':
+			tokens = append(tokens, "||")
+			i += 2
+		case r == ';':
+			return tokens, nil
+		default:
+			return nil, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+	return tokens, nil
+}
+
+type pluralExprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *pluralExprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *pluralExprParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *pluralExprParser) parseTernary() (pluralEval, error) {
+	cond, err := p.parseLogicalOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "?" {
+		return cond, nil
+	}
+	p.next()
+	thenExpr, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if p.next() != ":" {
+		return nil, fmt.Errorf("expected ':'")
+	}
+	elseExpr, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return func(n int) int {
+		if cond(n) != 0 {
+			return thenExpr(n)
+		}
+		return elseExpr(n)
+	}, nil
+}
+
+func (p *pluralExprParser) parseLogicalOr() (pluralEval, error) {
+	left, err := p.parseLogicalAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseLogicalAnd()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(n int) int { return boolToInt(l(n) != 0 || r(n) != 0) }
+	}
+	return left, nil
+}
+
+func (p *pluralExprParser) parseLogicalAnd() (pluralEval, error) {
+	left, err := p.parseEquality()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseEquality()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		left = func(n int) int { return boolToInt(l(n) != 0 && r(n) != 0) }
+	}
+	return left, nil
+}
+
+func (p *pluralExprParser) parseEquality() (pluralEval, error) {
+	left, err := p.parseRelational()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "==" || p.peek() == "!=" {
+		op := p.next()
+		right, err := p.parseRelational()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		if op == "==" {
+			left = func(n int) int { return boolToInt(l(n) == r(n)) }
+		} else {
+			left = func(n int) int { return boolToInt(l(n) != r(n)) }
+		}
+	}
+	return left, nil
+}
+
+func (p *pluralExprParser) parseRelational() (pluralEval, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != "<" && op != ">" && op != "<=" && op != ">=" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		switch op {
+		case "<":
+			left = func(n int) int { return boolToInt(l(n) < r(n)) }
+		case ">":
+			left = func(n int) int { return boolToInt(l(n) > r(n)) }
+		case "<=":
+			left = func(n int) int { return boolToInt(l(n) <= r(n)) }
+		case ">=":
+			left = func(n int) int { return boolToInt(l(n) >= r(n)) }
+		}
+	}
+}
+
+func (p *pluralExprParser) parseAdditive() (pluralEval, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		if op == "+" {
+			left = func(n int) int { return l(n) + r(n) }
+		} else {
+			left = func(n int) int { return l(n) - r(n) }
+		}
+	}
+	return left, nil
+}
+
+func (p *pluralExprParser) parseMultiplicative() (pluralEval, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		op := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l, r := left, right
+		switch op {
+		case "*":
+			left = func(n int) int { return l(n) * r(n) }
+		case "/":
+			left = func(n int) int {
+				if d := r(n); d != 0 {
+					return l(n) / d
+				}
+				return 0
+			}
+		case "%":
+			left = func(n int) int {
+				if d := r(n); d != 0 {
+					return l(n) % d
+				}
+				return 0
+			}
+		}
+	}
+	return left, nil
+}
+
+func (p *pluralExprParser) parseUnary() (pluralEval, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n int) int { return boolToInt(expr(n) == 0) }, nil
+	case "-":
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n int) int { return -expr(n) }, nil
+	default:
+		return p.parsePrimary()
+	}
+}
+
+func (p *pluralExprParser) parsePrimary() (pluralEval, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "(":
+		expr, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("expected ')'")
+		}
+		return expr, nil
+	case "n":
+		return func(n int) int { return n }, nil
+	default:
+		v, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected token %q", tok)
+		}
+		return func(int) int { return v }, nil
+	}
+}
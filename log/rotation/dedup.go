@@ -0,0 +1,244 @@
+package rotation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/richardwilkes/toolbox/errs"
+	"github.com/richardwilkes/toolbox/xio"
+)
+
+// dedupIndexFile is the name of the JSON index kept inside a dedup
+// directory, recording each blob's reference count and last-touched time.
+const dedupIndexFile = "index.json"
+
+// dedupLockFile is the advisory lock file used to serialize read-modify-
+// write access to dedupIndexFile across goroutines, processes, and even
+// hosts that share a dedupDir over a network filesystem.
+const dedupLockFile = dedupIndexFile + ".lock"
+
+// WithDedup enables content-addressed deduplication of rotated backups:
+// at rotation time, the outgoing backup is stored once, named by its
+// SHA-256 digest, under dir, and path-N becomes a link to that blob
+// instead of a copy of the content. This lets log directories that
+// repeatedly rotate near-identical content (as happens when a service is
+// restart-looping) collapse to a single on-disk copy, and makes off-host
+// shipping of the directory trivial, since blobs are immutable and named
+// by their own hash.
+//
+// WithDedup and WithCompression are mutually exclusive for a given
+// rotation: if both are configured, dedup takes precedence and
+// compression is skipped, since a deduped backup is already collapsed to
+// a shared blob.
+func WithDedup(dir string) func(*Rotator) error {
+	return func(r *Rotator) error {
+		// Resolved to an absolute path so the symlinks rotate() creates
+		// (which point into dir) remain valid no matter what directory the
+		// rotated file itself lives in, and so a later Readlink of one of
+		// them doesn't need to guess what it was relative to.
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return errs.Wrap(err)
+		}
+		if err = os.MkdirAll(abs, 0755); err != nil {
+			return errs.Wrap(err)
+		}
+		r.dedupDir = abs
+		return nil
+	}
+}
+
+// dedupBlob is one content-addressed blob's entry in the dedup index.
+type dedupBlob struct {
+	RefCount int       `json:"ref_count"`
+	ModTime  time.Time `json:"mod_time"`
+}
+
+type dedupIndex struct {
+	Blobs map[string]*dedupBlob `json:"blobs"`
+}
+
+func loadDedupIndex(dir string) (*dedupIndex, error) {
+	idx := &dedupIndex{Blobs: make(map[string]*dedupBlob)}
+	data, err := ioutil.ReadFile(filepath.Join(dir, dedupIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, errs.Wrap(err)
+	}
+	if err = json.Unmarshal(data, idx); err != nil {
+		return nil, errs.Wrap(err)
+	}
+	if idx.Blobs == nil {
+		idx.Blobs = make(map[string]*dedupBlob)
+	}
+	return idx, nil
+}
+
+func (idx *dedupIndex) save(dir string) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, dedupIndexFile), data, 0644); err != nil {
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+// withDedupIndexLock runs fn with dedupIndexFile's read-modify-write cycle
+// protected by a lock file in dir, so two rotators (in this process,
+// another process, or another host sharing dir) can't race a refcount
+// update and lose an increment or free a blob a live symlink still points
+// at. A plain create-exclusive lock file is used, rather than flock(2) or
+// similar, since it behaves the same on every platform and filesystem dir
+// might live on.
+func withDedupIndexLock(dir string, fn func() error) error {
+	lockPath := filepath.Join(dir, dedupLockFile)
+	const (
+		retryDelay = 10 * time.Millisecond
+		maxWait    = 5 * time.Second
+	)
+	deadline := time.Now().Add(maxWait)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			xio.CloseIgnoringErrors(lock)
+			break
+		}
+		if !os.IsExist(err) {
+			return errs.Wrap(err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for dedup index lock at %s", lockPath)
+		}
+		time.Sleep(retryDelay)
+	}
+	defer func() {
+		_ = os.Remove(lockPath) // Best-effort: a leftover lock makes every later caller time out after maxWait until it is removed.
+	}()
+	return fn()
+}
+
+// dedupBackup content-addresses the freshly rotated backup at path into
+// r.dedupDir and replaces it with a symlink (or, if the platform or
+// filesystem doesn't support symlinks there, a hardlink) to the blob,
+// incrementing its reference count in the index. path itself is only ever
+// replaced by an atomic rename of the finished link, so a failure partway
+// through (an unsupported filesystem, a permission error, etc.) leaves the
+// original backup intact instead of destroying it.
+func (r *Rotator) dedupBackup(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	tmp, err := ioutil.TempFile(r.dedupDir, "blob")
+	if err != nil {
+		xio.CloseIgnoringErrors(in)
+		return errs.Wrap(err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), in)
+	xio.CloseIgnoringErrors(in)
+	if cerr := tmp.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if rerr := os.Remove(tmp.Name()); rerr != nil && !os.IsNotExist(rerr) {
+			err = rerr
+		}
+		return errs.Wrap(err)
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	blobPath := filepath.Join(r.dedupDir, digest)
+	if _, err = os.Stat(blobPath); err == nil {
+		if err = os.Remove(tmp.Name()); err != nil {
+			return errs.Wrap(err)
+		}
+	} else if os.IsNotExist(err) {
+		if err = os.Rename(tmp.Name(), blobPath); err != nil {
+			return errs.Wrap(err)
+		}
+	} else {
+		return errs.Wrap(err)
+	}
+
+	// Build the replacement link next to path under a scratch name, then
+	// rename it over path. path is never removed up front, so if both the
+	// symlink and the hardlink attempt fail, the original backup is still
+	// there and this just returns an error.
+	linkTmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.dedup-tmp", filepath.Base(path)))
+	_ = os.Remove(linkTmp) // Clear out any stale leftover from a prior failed attempt.
+	if err = os.Symlink(blobPath, linkTmp); err != nil {
+		if err = os.Link(blobPath, linkTmp); err != nil {
+			return errs.Wrap(err)
+		}
+	}
+	if err = os.Rename(linkTmp, path); err != nil {
+		_ = os.Remove(linkTmp)
+		return errs.Wrap(err)
+	}
+
+	return withDedupIndexLock(r.dedupDir, func() error {
+		idx, err := loadDedupIndex(r.dedupDir)
+		if err != nil {
+			return err
+		}
+		blob, ok := idx.Blobs[digest]
+		if !ok {
+			blob = &dedupBlob{}
+			idx.Blobs[digest] = blob
+		}
+		blob.RefCount++
+		blob.ModTime = time.Now()
+		return idx.save(r.dedupDir)
+	})
+}
+
+// releaseDedupBackup decrements the reference count of the blob that the
+// backup at path links to, deleting the blob once nothing references it
+// any longer. It is a no-op for a backup that isn't a dedup symlink,
+// including one hardlinked to a blob: a hardlink is indistinguishable from
+// an ordinary file, so the reference dedupBackup counted for a hardlinked
+// backup is never released, and its blob stays in dedupDir after the
+// backup is removed.
+func releaseDedupBackup(dedupDir, path string) error {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil
+	}
+	if !filepath.IsAbs(target) {
+		// WithDedup always stores an absolute dedupDir, so a relative
+		// target would only come from a link created some other way;
+		// resolve it the same way the OS would when following the link.
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	digest := filepath.Base(target)
+	return withDedupIndexLock(dedupDir, func() error {
+		idx, err := loadDedupIndex(dedupDir)
+		if err != nil {
+			return err
+		}
+		blob, ok := idx.Blobs[digest]
+		if !ok {
+			return nil
+		}
+		blob.RefCount--
+		if blob.RefCount <= 0 {
+			delete(idx.Blobs, digest)
+			if rerr := os.Remove(target); rerr != nil && !os.IsNotExist(rerr) {
+				return errs.Wrap(rerr)
+			}
+		}
+		return idx.save(dedupDir)
+	})
+}
@@ -1,22 +1,55 @@
 package rotation
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/richardwilkes/toolbox/errs"
+	"github.com/richardwilkes/toolbox/log/logadapter"
+	"github.com/richardwilkes/toolbox/xio"
 )
 
+// gzipExt is the extension appended to a backup once it has been compressed.
+const gzipExt = ".gz"
+
+// Compression identifies the algorithm used to compress rotated backups.
+type Compression int
+
+const (
+	// NoCompression leaves rotated backups as-is.
+	NoCompression Compression = iota
+	// Gzip compresses rotated backups with gzip.
+	Gzip
+)
+
+// Schedule computes the next wall-clock rotation boundary that should
+// follow the given time. It is called once when the rotator (re)opens its
+// file, so implementations should return a time strictly after 'now'.
+type Schedule func(now time.Time) time.Time
+
 // Rotator holds the rotator data.
 type Rotator struct {
-	path       string
-	maxSize    int64
-	maxBackups int
-	lock       sync.Mutex
-	file       *os.File
-	size       int64
+	path         string
+	maxSize      int64
+	maxBackups   int
+	compression  Compression
+	maxAge       time.Duration
+	schedule     Schedule
+	nextRotateAt time.Time
+	opened       time.Time
+	dedupDir     string
+	compressWG   sync.WaitGroup
+	lock         sync.Mutex
+	file         *os.File
+	size         int64
+	// Log is used to report errors encountered while compressing a backup
+	// in the background. It is set to discard by default.
+	Log logadapter.ErrorLogger
 }
 
 // New creates a new Rotator with the specified options.
@@ -25,6 +58,7 @@ func New(options ...func(*Rotator) error) (*Rotator, error) {
 		path:       DefaultPath(),
 		maxSize:    DefaultMaxSize,
 		maxBackups: DefaultMaxBackups,
+		Log:        &logadapter.Discarder{},
 	}
 	for _, option := range options {
 		if err := option(r); err != nil {
@@ -34,6 +68,38 @@ func New(options ...func(*Rotator) error) (*Rotator, error) {
 	return r, nil
 }
 
+// WithCompression enables or disables gzip compression of rotated backups.
+// It has no effect if WithDedup is also configured; see its doc comment.
+func WithCompression(enabled bool) func(*Rotator) error {
+	return func(r *Rotator) error {
+		if enabled {
+			r.compression = Gzip
+		} else {
+			r.compression = NoCompression
+		}
+		return nil
+	}
+}
+
+// WithMaxAge causes a rotation to occur once the current file's age exceeds
+// the given duration, regardless of its size.
+func WithMaxAge(maxAge time.Duration) func(*Rotator) error {
+	return func(r *Rotator) error {
+		r.maxAge = maxAge
+		return nil
+	}
+}
+
+// WithRotateAt causes a rotation to occur whenever the wall-clock boundary
+// computed by schedule is crossed, regardless of size or age.
+func WithRotateAt(schedule Schedule) func(*Rotator) error {
+	return func(r *Rotator) error {
+		r.schedule = schedule
+		r.nextRotateAt = schedule(time.Now())
+		return nil
+	}
+}
+
 // Write implements io.Writer.
 func (r *Rotator) Write(b []byte) (int, error) {
 	r.lock.Lock()
@@ -51,6 +117,7 @@ func (r *Rotator) Write(b []byte) (int, error) {
 			}
 			r.file = file
 			r.size = 0
+			r.opened = time.Now()
 		case err != nil:
 			return 0, errs.Wrap(err)
 		default:
@@ -60,10 +127,23 @@ func (r *Rotator) Write(b []byte) (int, error) {
 			}
 			r.file = file
 			r.size = fi.Size()
+			r.opened = fi.ModTime()
+		}
+		if r.schedule != nil {
+			r.nextRotateAt = r.schedule(r.opened)
 		}
 	}
-	writeSize := int64(len(b))
-	if r.size+writeSize > r.maxSize {
+	now := time.Now()
+	switch {
+	case r.size+int64(len(b)) > r.maxSize:
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	case r.maxAge > 0 && now.Sub(r.opened) > r.maxAge:
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	case r.schedule != nil && !r.nextRotateAt.IsZero() && !now.Before(r.nextRotateAt):
 		if err := r.rotate(); err != nil {
 			return 0, err
 		}
@@ -76,7 +156,8 @@ func (r *Rotator) Write(b []byte) (int, error) {
 	return n, err
 }
 
-// Close implements io.Closer.
+// Close implements io.Closer. It waits for any in-flight background
+// compression of a rotated backup to finish before returning.
 func (r *Rotator) Close() error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -87,6 +168,7 @@ func (r *Rotator) Close() error {
 			return errs.Wrap(err)
 		}
 	}
+	r.compressWG.Wait()
 	return nil
 }
 
@@ -103,8 +185,23 @@ func (r *Rotator) rotate() error {
 			return errs.Wrap(err)
 		}
 	} else {
-		if err := os.Remove(fmt.Sprintf("%s-%d", r.path, r.maxBackups)); err != nil && !os.IsNotExist(err) {
-			return errs.Wrap(err)
+		// Wait for any compression left over from the previous rotation to
+		// finish before shifting backups around: otherwise the rename below
+		// could move path-1 out from under a still-running gzipFile reading
+		// it, leaving a stray uncompressed backup in the wrong slot and an
+		// orphaned .gz from the compression that lost the race.
+		r.compressWG.Wait()
+		oldest := fmt.Sprintf("%s-%d", r.path, r.maxBackups)
+		if r.dedupDir != "" {
+			if err := releaseDedupBackup(r.dedupDir, oldest); err != nil {
+				return err
+			}
+		}
+		if err := removeIfExists(oldest); err != nil {
+			return err
+		}
+		if err := removeIfExists(oldest + gzipExt); err != nil {
+			return err
 		}
 		for i := r.maxBackups; i > 0; i-- {
 			var oldPath string
@@ -113,9 +210,29 @@ func (r *Rotator) rotate() error {
 			} else {
 				oldPath = r.path
 			}
-			if err := os.Rename(oldPath, fmt.Sprintf("%s-%d", r.path, i)); err != nil && !os.IsNotExist(err) {
-				return errs.Wrap(err)
+			newPath := fmt.Sprintf("%s-%d", r.path, i)
+			if err := renameIfExists(oldPath, newPath); err != nil {
+				return err
 			}
+			if err := renameIfExists(oldPath+gzipExt, newPath+gzipExt); err != nil {
+				return err
+			}
+		}
+		backup := fmt.Sprintf("%s-%d", r.path, 1)
+		switch {
+		case r.dedupDir != "":
+			if r.compression != NoCompression {
+				// See the WithDedup doc comment: dedup and compression are
+				// mutually exclusive, and this is the only place that can
+				// notice a caller configured both.
+				r.Log.Errorf("rotation: WithDedup and WithCompression both configured for %s; dedup takes precedence, compression is ignored\n", r.path)
+			}
+			if err := r.dedupBackup(backup); err != nil {
+				return err
+			}
+		case r.compression != NoCompression:
+			r.compressWG.Add(1)
+			go r.compressBackup(backup)
 		}
 	}
 	file, err := os.Create(r.path)
@@ -124,5 +241,74 @@ func (r *Rotator) rotate() error {
 	}
 	r.file = file
 	r.size = 0
+	r.opened = time.Now()
+	if r.schedule != nil {
+		r.nextRotateAt = r.schedule(r.opened)
+	}
+	return nil
+}
+
+// compressBackup compresses path in the background and removes the
+// uncompressed original on success. Errors are reported via r.Log rather
+// than returned, since Write() has already moved on by the time this runs.
+func (r *Rotator) compressBackup(path string) {
+	defer r.compressWG.Done()
+	if err := gzipFile(path); err != nil {
+		r.Log.Error(err)
+	}
+}
+
+func gzipFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer xio.CloseIgnoringErrors(in)
+	dst := path + gzipExt
+	out, err := os.Create(dst)
+	if err != nil {
+		return errs.Wrap(err)
+	}
+	defer func() {
+		if err != nil {
+			xio.CloseIgnoringErrors(out)
+			if rerr := os.Remove(dst); rerr != nil && !os.IsNotExist(rerr) {
+				err = errs.Wrap(rerr)
+			}
+		}
+	}()
+	gw := gzip.NewWriter(out)
+	if _, err = io.Copy(gw, in); err != nil {
+		return errs.Wrap(err)
+	}
+	if err = gw.Close(); err != nil {
+		return errs.Wrap(err)
+	}
+	if err = out.Close(); err != nil {
+		return errs.Wrap(err)
+	}
+	if err = os.Remove(path); err != nil {
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errs.Wrap(err)
+	}
+	return nil
+}
+
+func renameIfExists(oldPath, newPath string) error {
+	if _, err := os.Stat(oldPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errs.Wrap(err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return errs.Wrap(err)
+	}
 	return nil
 }
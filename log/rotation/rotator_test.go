@@ -0,0 +1,111 @@
+package rotation
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestRotator(t *testing.T, path string, maxSize int64, maxBackups int, options ...func(*Rotator) error) *Rotator {
+	t.Helper()
+	opts := append([]func(*Rotator) error{
+		func(r *Rotator) error { r.path = path; return nil },
+		func(r *Rotator) error { r.maxSize = maxSize; return nil },
+		func(r *Rotator) error { r.maxBackups = maxBackups; return nil },
+	}, options...)
+	r, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func readGzipFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(gr); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func TestRotateCompressesBackupOnClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+
+	r := newTestRotator(t, path, 8, 2, WithCompression(true))
+	for i := 0; i < 4; i++ {
+		if _, err = r.Write([]byte("0123456789")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gzPath := path + "-1" + gzipExt
+	data, err := readGzipFile(gzPath)
+	if err != nil {
+		t.Fatalf("expected a compressed backup at %s: %v", gzPath, err)
+	}
+	if len(data) == 0 {
+		t.Fatal("expected non-empty decompressed backup content")
+	}
+	if _, err = os.Stat(path + "-1"); !os.IsNotExist(err) {
+		t.Fatalf("expected the uncompressed backup to be removed, stat err = %v", err)
+	}
+}
+
+// TestRotateWaitsForInFlightCompression guards against the race fixed by
+// waiting on compressWG in rotate() before it shifts backups: without that wait, a
+// rotation firing again before the previous backup finished compressing
+// could rename path-1 out from under the in-flight gzip read, leaving a
+// stray uncompressed backup and an orphaned .gz behind.
+func TestRotateWaitsForInFlightCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotation-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+
+	r := newTestRotator(t, path, 4, 1, WithCompression(true))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = r.Write([]byte("abcde"))
+		}()
+	}
+	wg.Wait()
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, plainErr := os.Stat(path + "-1")
+	_, gzErr := os.Stat(path + "-1" + gzipExt)
+	if plainErr == nil && gzErr == nil {
+		t.Fatal("found both a plain and a compressed backup at slot 1; compression raced a rotation")
+	}
+	if plainErr != nil && gzErr != nil {
+		t.Fatal("found neither a plain nor a compressed backup at slot 1")
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package safe
+
+import (
+	"os"
+	"syscall"
+)
+
+// isCrossDeviceRename reports whether err is the EXDEV error os.Rename
+// returns when oldname and newname live on different filesystems.
+func isCrossDeviceRename(err error) bool {
+	linkErr, ok := err.(*os.LinkError)
+	if !ok {
+		return false
+	}
+	errno, ok := linkErr.Err.(syscall.Errno)
+	return ok && errno == syscall.EXDEV
+}
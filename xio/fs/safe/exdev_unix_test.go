@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package safe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestCommitFallsBackOnEXDEV exercises the copy-then-rename fallback path in
+// Commit by substituting a fake os.Rename that fails with EXDEV once, the
+// way a real cross-filesystem rename would. isCrossDeviceRename only ever
+// reports true on non-Windows platforms, so this test is unix-only to match
+// exdev_unix.go.
+func TestCommitFallsBackOnEXDEV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "safe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "out.txt")
+
+	orig := osRename
+	defer func() { osRename = orig }()
+	triggered := false
+	osRename = func(oldname, newname string) error {
+		if !triggered {
+			triggered = true
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+		}
+		return orig(oldname, newname)
+	}
+
+	f, err := Create(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Commit(); err != nil {
+		t.Fatalf("commit should fall back to copy-and-remove on EXDEV: %v", err)
+	}
+	if !triggered {
+		t.Fatal("expected the fake EXDEV rename to have been exercised")
+	}
+
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "content" {
+		t.Fatalf("expected %q, got %q", "content", data)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package safe
+
+// isCrossDeviceRename always reports false on Windows, which does not use
+// the EXDEV errno for cross-volume renames the way POSIX systems do.
+func isCrossDeviceRename(err error) bool {
+	return false
+}
@@ -1,6 +1,7 @@
 package safe
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -9,16 +10,67 @@ import (
 	"github.com/richardwilkes/toolbox/xio"
 )
 
+// DefaultSync is the default value given to a File's Sync field.
+const DefaultSync = true
+
+// FSFile is the subset of *os.File that safe needs from a file handle
+// returned by an FS.
+type FSFile interface {
+	io.Writer
+	io.Closer
+	Name() string
+	Chmod(mode os.FileMode) error
+	Sync() error
+}
+
+// FS is the subset of filesystem operations safe needs to perform an atomic
+// write. It matches the corresponding methods of afero.Fs, so an
+// afero-backed implementation (in-memory, base-path-scoped, copy-on-write,
+// etc.) can be plugged in without requiring afero as a dependency of this
+// package. OS defaults to an OS-backed implementation.
+type FS interface {
+	TempFile(dir, prefix string) (FSFile, error)
+	Rename(oldname, newname string) error
+	Remove(name string) error
+}
+
+// OS is the default FS implementation, backed by the local filesystem.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) TempFile(dir, prefix string) (FSFile, error) {
+	return ioutil.TempFile(dir, prefix)
+}
+
+// osRename is a variable, rather than a direct call to os.Rename, so tests
+// can substitute a fake cross-device (EXDEV) failure without needing two
+// real filesystems.
+var osRename = os.Rename
+
+func (osFS) Rename(oldname, newname string) error {
+	return osRename(oldname, newname)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
 // File provides safe, atomic saving of files. Instead of truncating and
 // overwriting the destination file, it creates a temporary file in the same
 // directory, writes to it, and then renames the temporary file to the
 // original name when Commit() is called. If Close() is called without calling
 // Commit(), or the Commit() fails, then the original file is left untouched.
 type File struct {
-	*os.File
+	FSFile
+	fs           FS
 	originalName string
-	committed    bool
-	closed       bool
+	// Sync controls whether Commit fsyncs the temp file and the destination
+	// directory before considering the write durable. It defaults to true;
+	// set it to false to trade durability for speed.
+	Sync      bool
+	committed bool
+	closed    bool
 }
 
 // Create creates a temporary file in the same directory as filename,
@@ -30,26 +82,55 @@ func Create(filename string) (*File, error) {
 // CreateWithMode creates a temporary file in the same directory as filename,
 // which will be renamed to the given filename when calling Commit.
 func CreateWithMode(filename string, mode os.FileMode) (*File, error) {
+	return CreateOn(OS, filename, mode)
+}
+
+// CreateOn creates a temporary file in the same directory as filename,
+// using the given FS, which will be renamed to the given filename when
+// calling Commit. This allows callers that already carry an afero-style
+// filesystem around (in-memory for tests, base-path-scoped for sandboxing,
+// copy-on-write, etc.) to perform atomic writes against it.
+func CreateOn(fs FS, filename string, mode os.FileMode) (*File, error) {
 	filename = filepath.Clean(filename)
 	if len(filename) == 0 || filename[len(filename)-1] == filepath.Separator {
 		return nil, os.ErrInvalid
 	}
-	f, err := ioutil.TempFile(filepath.Dir(filename), "safe")
+	return createIn(fs, filepath.Dir(filename), filename, mode)
+}
+
+// CreateInDir creates a temporary file in dir, rather than in the same
+// directory as filename, which will be renamed to the given filename when
+// calling Commit. Commit still renames into filename's directory (and its
+// copy-then-remove fallback, used if dir and filename turn out to live on
+// different filesystems, creates a temp file there too), so that directory
+// must be writable.
+func CreateInDir(dir, filename string, mode os.FileMode) (*File, error) {
+	filename = filepath.Clean(filename)
+	if len(filename) == 0 || filename[len(filename)-1] == filepath.Separator {
+		return nil, os.ErrInvalid
+	}
+	return createIn(OS, dir, filename, mode)
+}
+
+func createIn(fs FS, dir, filename string, mode os.FileMode) (*File, error) {
+	f, err := fs.TempFile(dir, "safe")
 	if err != nil {
 		return nil, err
 	}
 	if runtime.GOOS != "windows" { // Windows doesn't support changing the mode
 		if err = f.Chmod(mode); err != nil {
 			xio.CloseIgnoringErrors(f)
-			if rerr := os.Remove(f.Name()); rerr != nil && err == nil {
+			if rerr := fs.Remove(f.Name()); rerr != nil && err == nil {
 				err = rerr // Won't happen, but here to quiet the linter
 			}
 			return nil, err
 		}
 	}
 	return &File{
-		File:         f,
+		FSFile:       f,
+		fs:           fs,
 		originalName: filename,
+		Sync:         DefaultSync,
 	}, nil
 }
 
@@ -69,22 +150,95 @@ func (f *File) Commit() error {
 	}
 	f.committed = true
 	f.closed = true
-	err := f.Sync()
-	if cerr := f.File.Close(); cerr != nil && err == nil {
+	var err error
+	if f.Sync {
+		err = f.FSFile.Sync()
+	}
+	if cerr := f.FSFile.Close(); cerr != nil && err == nil {
 		err = cerr
 	}
 	name := f.Name()
 	if err == nil {
-		err = os.Rename(name, f.originalName)
+		if err = f.fs.Rename(name, f.originalName); err != nil && f.fs == OS && isCrossDeviceRename(err) {
+			err = copyAndRemove(name, f.originalName)
+		}
+	}
+	// The directory fsync and the EXDEV fallback both operate on real
+	// filesystem paths, which only makes sense when fs is actually backed
+	// by the OS: an in-memory or other virtual FS's "directory" generally
+	// doesn't exist on disk at all.
+	if err == nil && f.Sync && f.fs == OS {
+		err = syncParentDir(f.originalName)
 	}
 	if err != nil {
-		if rerr := os.Remove(name); rerr != nil && err == nil {
+		if rerr := f.fs.Remove(name); rerr != nil && err == nil {
 			err = rerr
 		}
 	}
 	return err
 }
 
+// syncParentDir fsyncs the directory containing filename, which POSIX
+// requires for a preceding rename into that directory to be durable. It is
+// a no-op on Windows, which has no equivalent operation.
+func syncParentDir(filename string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
+	defer xio.CloseIgnoringErrors(dir)
+	return dir.Sync()
+}
+
+// copyAndRemove is the fallback used when Rename fails across filesystems
+// (EXDEV). Copying straight into dst would leave it truncated and
+// corrupted if interrupted, which is exactly what safe exists to avoid, so
+// instead it copies src's contents into a temp file in dst's directory
+// (the same filesystem dst is on), fsyncs and renames that into place, and
+// only then removes src.
+func copyAndRemove(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer xio.CloseIgnoringErrors(in)
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "safe")
+	if err != nil {
+		return err
+	}
+	if err = tmp.Chmod(fi.Mode()); err != nil {
+		xio.CloseIgnoringErrors(tmp)
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err = io.Copy(tmp, in); err != nil {
+		xio.CloseIgnoringErrors(tmp)
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		xio.CloseIgnoringErrors(tmp)
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
 // Close the temporary file and remove it, if it hasn't already been
 // committed. If it has been committed, nothing happens.
 func (f *File) Close() error {
@@ -95,8 +249,8 @@ func (f *File) Close() error {
 		return os.ErrInvalid
 	}
 	f.closed = true
-	err := f.File.Close()
-	if rerr := os.Remove(f.Name()); rerr != nil && err == nil {
+	err := f.FSFile.Close()
+	if rerr := f.fs.Remove(f.Name()); rerr != nil && err == nil {
 		err = rerr
 	}
 	return err
@@ -0,0 +1,153 @@
+package safe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestCreateCommitReplacesExistingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "safe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "out.txt")
+	if err = ioutil.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Create(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Fatalf("expected %q, got %q", "new", data)
+	}
+}
+
+func TestCloseWithoutCommitLeavesOriginalUntouched(t *testing.T) {
+	dir, err := ioutil.TempDir("", "safe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "out.txt")
+	if err = ioutil.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Create(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	tmpName := f.Name()
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old" {
+		t.Fatalf("expected original content %q to survive, got %q", "old", data)
+	}
+	if _, err = os.Stat(tmpName); !os.IsNotExist(err) {
+		t.Fatalf("expected temp file %s to be removed, stat err = %v", tmpName, err)
+	}
+}
+
+// memFS is a minimal in-memory FS, used to confirm that CreateOn works
+// against a virtual filesystem and that Commit skips the directory-fsync
+// and EXDEV fallback for any fs other than OS.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMemFS() *memFS { return &memFS{files: make(map[string][]byte)} }
+
+type memFile struct {
+	fs   *memFS
+	name string
+	data []byte
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.data = append(f.data, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	f.fs.files[f.name] = f.data
+	return nil
+}
+
+func (f *memFile) Name() string                 { return f.name }
+func (f *memFile) Chmod(mode os.FileMode) error { return nil }
+func (f *memFile) Sync() error                  { return nil }
+
+func (fs *memFS) TempFile(dir, prefix string) (FSFile, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	name := filepath.Join(dir, fmt.Sprintf("%s-tmp", prefix))
+	return &memFile{fs: fs, name: name}, nil
+}
+
+func (fs *memFS) Rename(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	data, ok := fs.files[oldname]
+	if !ok {
+		return os.ErrNotExist
+	}
+	fs.files[newname] = data
+	delete(fs.files, oldname)
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.files, name)
+	return nil
+}
+
+func TestCreateOnVirtualFS(t *testing.T) {
+	fs := newMemFS()
+	f, err := CreateOn(fs, "/virtual/dir/out.txt", 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	// "/virtual/dir" doesn't exist on the real filesystem, so Commit must
+	// not try to fsync it: that's only correct when fs is OS.
+	if err = f.Commit(); err != nil {
+		t.Fatalf("commit on a virtual FS should not touch the real filesystem: %v", err)
+	}
+	if got := string(fs.files["/virtual/dir/out.txt"]); got != "hello" {
+		t.Fatalf("expected committed content %q, got %q", "hello", got)
+	}
+}
@@ -0,0 +1,47 @@
+package xhttp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// Authenticator validates incoming requests and, when it cannot, supplies
+// the challenge that tells the client how to retry with credentials.
+type Authenticator interface {
+	// Authenticate checks the request's credentials, returning the
+	// authenticated user name and true if they are valid.
+	Authenticate(req *http.Request) (user string, ok bool)
+	// Challenge adds the WWW-Authenticate header (and any other state) that
+	// tells the client how to retry the request with credentials.
+	Challenge(w http.ResponseWriter)
+}
+
+// Wrap returns an http.Handler that requires auth to succeed before calling
+// handler.
+func Wrap(auth Authenticator, handler http.Handler) http.Handler {
+	return &wrapper{auth: auth, handler: handler}
+}
+
+type wrapper struct {
+	auth    Authenticator
+	handler http.Handler
+}
+
+func (hw *wrapper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, ok := hw.auth.Authenticate(req); ok {
+		hw.handler.ServeHTTP(w, req)
+		return
+	}
+	hw.auth.Challenge(w)
+	WriteHTTPStatus(w, http.StatusUnauthorized)
+}
+
+// constantTimeStringsEqual reports whether a and b are equal, without
+// leaking their length or content through timing, by comparing the SHA-256
+// hash of each.
+func constantTimeStringsEqual(a, b string) bool {
+	ah := sha256.Sum256([]byte(a))
+	bh := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ah[:], bh[:]) == 1
+}
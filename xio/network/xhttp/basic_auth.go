@@ -19,23 +19,20 @@ func NewBasicAuth(realm string, lookup PasswordLookup) *BasicAuth {
 	return &BasicAuth{realm: realm, lookup: lookup}
 }
 
-// Wrap an http.Handler.
-func (auth *BasicAuth) Wrap(handler http.Handler) http.Handler {
-	return &wrapper{auth: auth, handler: handler}
-}
-
-type wrapper struct {
-	auth    *BasicAuth
-	handler http.Handler
+// Authenticate implements Authenticator.
+func (auth *BasicAuth) Authenticate(req *http.Request) (string, bool) {
+	user, pw, ok := req.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	expected := auth.lookup(user, auth.realm)
+	if expected == "" || !constantTimeStringsEqual(pw, expected) {
+		return "", false
+	}
+	return user, true
 }
 
-func (hw *wrapper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	if user, pw, ok := req.BasicAuth(); ok {
-		if pw == hw.auth.lookup(user, hw.auth.realm) {
-			hw.handler.ServeHTTP(w, req)
-			return
-		}
-	}
-	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, hw.auth.realm))
-	WriteHTTPStatus(w, http.StatusUnauthorized)
+// Challenge implements Authenticator.
+func (auth *BasicAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, auth.realm))
 }
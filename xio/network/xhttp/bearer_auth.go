@@ -0,0 +1,38 @@
+package xhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BearerVerifier validates an opaque bearer token (which may be a simple
+// opaque string or a self-contained token such as a JWT) and returns the
+// user it identifies.
+type BearerVerifier func(token string) (user string, ok bool)
+
+// BearerAuth provides RFC 6750 Bearer token authentication.
+type BearerAuth struct {
+	realm    string
+	verifier BearerVerifier
+}
+
+// NewBearerAuth creates a new BearerAuth.
+func NewBearerAuth(realm string, verifier BearerVerifier) *BearerAuth {
+	return &BearerAuth{realm: realm, verifier: verifier}
+}
+
+// Authenticate implements Authenticator.
+func (auth *BearerAuth) Authenticate(req *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return "", false
+	}
+	return auth.verifier(strings.TrimPrefix(header, prefix))
+}
+
+// Challenge implements Authenticator.
+func (auth *BearerAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, auth.realm))
+}
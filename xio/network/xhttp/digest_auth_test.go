@@ -0,0 +1,102 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func extractDigestParam(t *testing.T, header, key string) string {
+	t.Helper()
+	params := parseDigestParams(strings.TrimPrefix(header, "Digest "))
+	v, ok := params[key]
+	if !ok {
+		t.Fatalf("missing %s in challenge %q", key, header)
+	}
+	return v
+}
+
+func TestDigestAuthChallengeResponseAndReplay(t *testing.T) {
+	const user, realm, password = "alice", "test-realm", "s3cret"
+	ha1 := hexDigest(MD5.newHash(), user+":"+realm+":"+password)
+	auth := NewDigestAuth(realm, MD5, func(u, r string) (string, bool) {
+		if u == user && r == realm {
+			return ha1, true
+		}
+		return "", false
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	if _, ok := auth.Authenticate(req); ok {
+		t.Fatal("expected authentication to fail without credentials")
+	}
+
+	rec := httptest.NewRecorder()
+	auth.Challenge(rec)
+	challenge := rec.Header().Get("WWW-Authenticate")
+	nonce := extractDigestParam(t, challenge, "nonce")
+
+	buildAuthHeader := func(nc, cnonce string) string {
+		h := MD5.newHash()
+		ha2 := hexDigest(h, "GET:/secret")
+		response := hexDigest(h, strings.Join([]string{ha1, nonce, nc, cnonce, "auth", ha2}, ":"))
+		return `Digest username="alice", realm="test-realm", nonce="` + nonce +
+			`", uri="/secret", qop=auth, nc=` + nc + `, cnonce="` + cnonce +
+			`", response="` + response + `"`
+	}
+
+	header := buildAuthHeader("00000001", "abcd1234")
+	req2 := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req2.Header.Set("Authorization", header)
+	if u, ok := auth.Authenticate(req2); !ok || u != user {
+		t.Fatalf("expected successful authentication, got ok=%v user=%q", ok, u)
+	}
+
+	// Replaying the exact same request (same nonce, same nc) must be rejected.
+	req3 := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req3.Header.Set("Authorization", header)
+	if _, ok := auth.Authenticate(req3); ok {
+		t.Fatal("expected a replayed nc on the same nonce to be rejected")
+	}
+
+	// A higher nc on the same nonce must still succeed.
+	header2 := buildAuthHeader("00000002", "abcd1234")
+	req4 := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req4.Header.Set("Authorization", header2)
+	if _, ok := auth.Authenticate(req4); !ok {
+		t.Fatal("expected a higher nc on the same nonce to succeed")
+	}
+
+	// And replaying header2 itself (the same nc again) must fail.
+	req5 := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req5.Header.Set("Authorization", header2)
+	if _, ok := auth.Authenticate(req5); ok {
+		t.Fatal("expected replaying the latest nc to be rejected")
+	}
+}
+
+func TestDigestAuthWrongPasswordRejected(t *testing.T) {
+	const user, realm = "alice", "test-realm"
+	correctHA1 := hexDigest(MD5.newHash(), user+":"+realm+":"+"s3cret")
+	auth := NewDigestAuth(realm, MD5, func(u, r string) (string, bool) {
+		return correctHA1, u == user && r == realm
+	})
+
+	rec := httptest.NewRecorder()
+	auth.Challenge(rec)
+	nonce := extractDigestParam(t, rec.Header().Get("WWW-Authenticate"), "nonce")
+
+	wrongHA1 := hexDigest(MD5.newHash(), user+":"+realm+":"+"wrong")
+	h := MD5.newHash()
+	ha2 := hexDigest(h, "GET:/secret")
+	response := hexDigest(h, strings.Join([]string{wrongHA1, nonce, "00000001", "abcd1234", "auth", ha2}, ":"))
+	header := `Digest username="alice", realm="test-realm", nonce="` + nonce +
+		`", uri="/secret", qop=auth, nc=00000001, cnonce="abcd1234", response="` + response + `"`
+
+	req := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	req.Header.Set("Authorization", header)
+	if _, ok := auth.Authenticate(req); ok {
+		t.Fatal("expected authentication with the wrong password to fail")
+	}
+}
@@ -0,0 +1,32 @@
+package xhttp
+
+import "net/http"
+
+// MultiAuth tries a series of Authenticators in order, succeeding if any of
+// them does, and otherwise offers all of their challenges in a single
+// response, as RFC 7235 permits more than one WWW-Authenticate header.
+type MultiAuth struct {
+	auths []Authenticator
+}
+
+// NewMultiAuth creates a new MultiAuth.
+func NewMultiAuth(auths ...Authenticator) *MultiAuth {
+	return &MultiAuth{auths: auths}
+}
+
+// Authenticate implements Authenticator.
+func (auth *MultiAuth) Authenticate(req *http.Request) (string, bool) {
+	for _, one := range auth.auths {
+		if user, ok := one.Authenticate(req); ok {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// Challenge implements Authenticator.
+func (auth *MultiAuth) Challenge(w http.ResponseWriter) {
+	for _, one := range auth.auths {
+		one.Challenge(w)
+	}
+}